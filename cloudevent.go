@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// buildCloudEvent renders a CloudEvent from event, using --ce-source,
+// --ce-type, --ce-subject and --ce-extensions as Go templates evaluated
+// against event. id, time and datacontenttype are always auto-populated, and
+// the event's entity/check/namespace are always attached as standard
+// extensions so they survive even if --ce-extensions is unset.
+func buildCloudEvent(event *corev2.Event) (cloudevents.Event, error) {
+	ce := cloudevents.NewEvent()
+
+	ce.SetID(uuid.New().String())
+	ce.SetTime(checkExecutedTime(event))
+	ce.SetSource(config.ceSource)
+
+	ceType, err := renderCloudEventTemplate("ce-type", config.ceType, event)
+	if err != nil {
+		return ce, err
+	}
+	ce.SetType(ceType)
+
+	subject, err := renderCloudEventTemplate("ce-subject", config.ceSubject, event)
+	if err != nil {
+		return ce, err
+	}
+	ce.SetSubject(subject)
+
+	if event.Entity != nil {
+		ce.SetExtension("sensuentity", event.Entity.Name)
+	}
+	if event.Check != nil {
+		ce.SetExtension("sensucheck", event.Check.Name)
+		ce.SetExtension("sensustatus", event.Check.Status)
+	}
+	ce.SetExtension("sensunamespace", event.Namespace)
+
+	for _, extension := range config.ceExtensions {
+		key, valueTemplate, err := splitCloudEventExtension(extension)
+		if err != nil {
+			return ce, err
+		}
+		value, err := renderCloudEventTemplate(fmt.Sprintf("ce-extensions[%s]", key), valueTemplate, event)
+		if err != nil {
+			return ce, err
+		}
+		ce.SetExtension(key, value)
+	}
+
+	if err := ce.SetData(cloudevents.ApplicationJSON, event); err != nil {
+		return ce, fmt.Errorf("Failed to set cloudevent data: %v", err)
+	}
+
+	return ce, nil
+}
+
+// deliveryFieldsFor extracts the entity/check/status attributes logged
+// alongside every RHOSE delivery attempt for ce.
+func deliveryFieldsFor(event *corev2.Event, ce cloudevents.Event) deliveryLogFields {
+	fields := deliveryLogFields{CEID: ce.ID()}
+	if event.Entity != nil {
+		fields.Entity = event.Entity.Name
+	}
+	if event.Check != nil {
+		fields.Check = event.Check.Name
+		fields.Status = event.Check.Status
+	}
+	return fields
+}
+
+// checkExecutedTime returns the check's Executed timestamp, falling back to
+// the current time when the event carries no check (e.g. a metrics-only
+// event).
+func checkExecutedTime(event *corev2.Event) time.Time {
+	if event.Check == nil || event.Check.Executed == 0 {
+		return time.Now().UTC()
+	}
+	return time.Unix(event.Check.Executed, 0).UTC()
+}
+
+// renderCloudEventTemplate parses tmplText as a Go template named name and
+// executes it against event.
+func renderCloudEventTemplate(name, tmplText string, event *corev2.Event) (string, error) {
+	tmpl, err := template.New(name).Parse(tmplText)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse --%s template: %v", name, err)
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, event); err != nil {
+		return "", fmt.Errorf("Failed to render --%s template: %v", name, err)
+	}
+	return rendered.String(), nil
+}
+
+// splitCloudEventExtension splits a --ce-extensions value of the form
+// key=templateValue.
+func splitCloudEventExtension(extension string) (string, string, error) {
+	parts := strings.SplitN(extension, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid --ce-extensions value %q: expected key=template", extension)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withCEConfig points config at the given CloudEvent templates, restoring
+// the previous values afterwards.
+func withCEConfig(t *testing.T, source, ceTypeTemplate, subjectTemplate string, extensions []string) {
+	t.Helper()
+	origSource, origType, origSubject, origExtensions := config.ceSource, config.ceType, config.ceSubject, config.ceExtensions
+	t.Cleanup(func() {
+		config.ceSource, config.ceType, config.ceSubject, config.ceExtensions = origSource, origType, origSubject, origExtensions
+	})
+	config.ceSource = source
+	config.ceType = ceTypeTemplate
+	config.ceSubject = subjectTemplate
+	config.ceExtensions = extensions
+}
+
+func TestBuildCloudEvent_RendersTemplatesAndStandardExtensions(t *testing.T) {
+	withCEConfig(t, "sensu/sensu-rhose-handler", defaultCEType, defaultCESubject, []string{"team=platform"})
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	event.Check.Executed = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Unix()
+
+	ce, err := buildCloudEvent(event)
+	require.NoError(t, err)
+
+	assert.Equal(t, "sensu/sensu-rhose-handler", ce.Source())
+	assert.Equal(t, "io.sensu.check.2", ce.Type())
+	assert.Equal(t, "entity1/check1", ce.Subject())
+	assert.NotEmpty(t, ce.ID())
+	assert.True(t, ce.Time().Equal(time.Unix(event.Check.Executed, 0).UTC()))
+
+	extensions := ce.Extensions()
+	assert.Equal(t, "entity1", extensions["sensuentity"])
+	assert.Equal(t, "check1", extensions["sensucheck"])
+	assert.Equal(t, int32(2), extensions["sensustatus"])
+	assert.Equal(t, "default", extensions["sensunamespace"])
+	assert.Equal(t, "platform", extensions["team"])
+}
+
+func TestBuildCloudEvent_InvalidExtensionIsRejected(t *testing.T) {
+	withCEConfig(t, defaultCESource, defaultCEType, defaultCESubject, []string{"no-equals-sign"})
+
+	_, err := buildCloudEvent(corev2.FixtureEvent("entity1", "check1"))
+	require.Error(t, err)
+}
+
+func TestBuildCloudEvent_InvalidTemplateIsRejected(t *testing.T) {
+	withCEConfig(t, defaultCESource, "{{.NotAField", defaultCESubject, nil)
+
+	_, err := buildCloudEvent(corev2.FixtureEvent("entity1", "check1"))
+	require.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	loggerMutex sync.Mutex
+	logger      *slog.Logger
+)
+
+// getLogger returns the process-wide structured logger, building it on first
+// use from --log-level/--log-format so flag parsing has already happened by
+// the time the handler/text/json choice is made.
+func getLogger() *slog.Logger {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+
+	if logger != nil {
+		return logger
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: parseLogLevel(config.logLevel)}
+	var handler slog.Handler
+	if config.logFormat == logFormatJSON {
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	}
+	logger = slog.New(handler)
+	return logger
+}
+
+// parseLogLevel maps --log-level to a slog.Level, defaulting to Info for an
+// unrecognized value.
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
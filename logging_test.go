@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"debug":       slog.LevelDebug,
+		"DEBUG":       slog.LevelDebug,
+		"warn":        slog.LevelWarn,
+		"warning":     slog.LevelWarn,
+		"error":       slog.LevelError,
+		"info":        slog.LevelInfo,
+		"":            slog.LevelInfo,
+		"not-a-level": slog.LevelInfo,
+	}
+	for level, want := range cases {
+		assert.Equal(t, want, parseLogLevel(level), "level %q", level)
+	}
+}
+
+// withCapturedLogger points the package logger singleton at a text handler
+// writing to a buffer, so tests can assert on what was actually logged,
+// restoring the previous logger afterwards.
+func withCapturedLogger(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	origLogger := logger
+	t.Cleanup(func() { logger = origLogger })
+
+	var buf bytes.Buffer
+	logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return &buf
+}
+
+func TestLogDryRunRequest_RedactsAuthorizationHeader(t *testing.T) {
+	buf := withCapturedLogger(t)
+
+	origURL := config.rhoseURL
+	t.Cleanup(func() { config.rhoseURL = origURL })
+	config.rhoseURL = "http://example.invalid"
+
+	fields := deliveryLogFields{Entity: "entity1", Check: "check1", Status: 0, CEID: "ce-id-1"}
+	err := logDryRunRequest(context.Background(), "a-secret-token", []byte(`{"some":"payload"}`), fields)
+	require.NoError(t, err)
+
+	logged := buf.String()
+	assert.Contains(t, logged, "dry-run: would send event to RHOSE")
+	assert.NotContains(t, logged, "a-secret-token", "the bearer token must never reach the dry-run log")
+	assert.Contains(t, logged, "Authorization:REDACTED", "the Authorization header must be logged as redacted")
+}
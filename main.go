@@ -1,31 +1,17 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
-
-	cloudevents "github.com/cloudevents/sdk-go/v2"
 
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 
 	"github.com/sensu/sensu-plugin-sdk/sensu"
 )
 
-// AccessTokenResponse contains the Authorization response object from keycloak
-type AccessTokenResponse struct {
-	AccessToken      string `json:"access_token"`
-	ExpiresAt        int    `json:"expires_at"`
-	RefreshExpiresIn int    `json:"refresh_expires_in"`
-	TokenType        string `json:"token_type"`
-	Scope            string `json:"scope"`
-	NotBeforePolicy  int    `json:"not-before-policy"`
-}
-
 // HandlerConfig contains the Slack handler configuration
 type HandlerConfig struct {
 	sensu.PluginConfig
@@ -34,6 +20,23 @@ type HandlerConfig struct {
 	clientSecret          string
 	ssoURL                string
 	authenticationEnabled string
+	jwksURL               string
+	tokenLeewaySeconds    int
+	authType              string
+	refreshTokenFile      string
+	keyFile               string
+	staticToken           string
+	maxRetries            int
+	httpTimeoutSeconds    int
+	secretBackend         string
+	keyringService        string
+	ceSource              string
+	ceType                string
+	ceSubject             string
+	ceExtensions          []string
+	dryRun                bool
+	logLevel              string
+	logFormat             string
 }
 
 const (
@@ -42,12 +45,57 @@ const (
 	clientSecret          = "client-secret"
 	ssoURL                = "sso-url"
 	authenticationEnabled = "authentication-enabled"
+	jwksURL               = "jwks-url"
+	tokenLeewaySeconds    = "token-leeway-seconds"
+	authType              = "auth-type"
+	refreshTokenFile      = "refresh-token-file"
+	keyFile               = "key-file"
+	staticToken           = "static-token"
+	maxRetries            = "max-retries"
+	httpTimeout           = "http-timeout"
+	secretBackend         = "secret-backend"
+	keyringService        = "keyring-service"
+	ceSource              = "ce-source"
+	ceType                = "ce-type"
+	ceSubject             = "ce-subject"
+	ceExtensions          = "ce-extensions"
+	dryRun                = "dry-run"
+	logLevel              = "log-level"
+	logFormat             = "log-format"
 
 	defaultAuthenticationEnabled = "no"
+	defaultTokenLeewaySeconds    = 30
+	defaultMaxRetries            = 5
+	defaultHTTPTimeoutSeconds    = 10
+	defaultSecretBackend         = secretBackendEnv
+	defaultKeyringService        = "sensu-rhose-handler"
+	defaultCESource              = "sensu/sensu-rhose-handler"
+	defaultCEType                = "io.sensu.check.{{.Check.Status}}"
+	defaultCESubject             = "{{.Entity.Name}}/{{.Check.Name}}"
+	defaultLogLevel              = "info"
+	defaultLogFormat             = logFormatText
+
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	authTypeNone              = "none"
+	authTypeClientCredentials = "client_credentials"
+	authTypeRefreshToken      = "refresh_token"
+	authTypeKeyFile           = "keyfile"
+	authTypeStatic            = "static"
+
+	secretBackendEnv     = "env"
+	secretBackendFile    = "file"
+	secretBackendKeyring = "keyring"
+
+	// defaultRefreshTokenKeyringKey is the keyring item key used to store the
+	// rotated refresh token when --refresh-token-file is unset.
+	defaultRefreshTokenKeyringKey = "refresh-token"
 )
 
-var cachedToken *AccessTokenResponse
-var used string = "no"
+// tokenProvider supplies the bearer token to attach to outgoing RHOSE
+// requests. It is resolved from --auth-type in checkArgs.
+var tokenProvider TokenProvider
 
 var (
 	config = HandlerConfig{
@@ -74,7 +122,7 @@ var (
 			Argument:  clientID,
 			Shorthand: "c",
 			Secret:    true,
-			Usage:     "The client id",
+			Usage:     "The client id, or a path to a file containing it when --secret-backend=file",
 			Value:     &config.clientID,
 		},
 		{
@@ -83,7 +131,7 @@ var (
 			Argument:  clientSecret,
 			Shorthand: "s",
 			Secret:    true,
-			Usage:     "The client secret",
+			Usage:     "The client secret, or a path to a file containing it when --secret-backend=file",
 			Value:     &config.clientSecret,
 		},
 		{
@@ -105,6 +153,136 @@ var (
 			Usage:     "Is the authentication enabled",
 			Value:     &config.authenticationEnabled,
 		},
+		{
+			Path:     jwksURL,
+			Env:      "RHOSE_JWKS_URL",
+			Argument: jwksURL,
+			Usage:    "The JWKS url used to verify the SSO access token, discovered from sso-url's openid-configuration if unset",
+			Value:    &config.jwksURL,
+		},
+		{
+			Path:     tokenLeewaySeconds,
+			Env:      "RHOSE_TOKEN_LEEWAY_SECONDS",
+			Argument: tokenLeewaySeconds,
+			Default:  defaultTokenLeewaySeconds,
+			Usage:    "The number of seconds before the access token's expiry at which it is treated as expired",
+			Value:    &config.tokenLeewaySeconds,
+		},
+		{
+			Path:     authType,
+			Env:      "RHOSE_AUTH_TYPE",
+			Argument: authType,
+			Usage:    "The token provider to use: none, client_credentials, refresh_token, keyfile or static. Defaults based on --authentication-enabled",
+			Value:    &config.authType,
+		},
+		{
+			Path:     refreshTokenFile,
+			Env:      "RHOSE_REFRESH_TOKEN_FILE",
+			Argument: refreshTokenFile,
+			Usage:    "Path to a file holding a long-lived refresh token (the keyring item key when --secret-backend=keyring), used when --auth-type=refresh_token",
+			Value:    &config.refreshTokenFile,
+		},
+		{
+			Path:     keyFile,
+			Env:      "RHOSE_KEY_FILE",
+			Argument: keyFile,
+			Usage:    "Path to a JSON credentials file ({client_id, client_secret, issuer_url, type}), used when --auth-type=keyfile",
+			Value:    &config.keyFile,
+		},
+		{
+			Path:     staticToken,
+			Env:      "RHOSE_STATIC_TOKEN",
+			Argument: staticToken,
+			Secret:   true,
+			Usage:    "A pre-issued bearer token to send as-is, used when --auth-type=static",
+			Value:    &config.staticToken,
+		},
+		{
+			Path:     maxRetries,
+			Env:      "RHOSE_MAX_RETRIES",
+			Argument: maxRetries,
+			Default:  defaultMaxRetries,
+			Usage:    "The maximum number of times to retry delivering an event to RHOSE",
+			Value:    &config.maxRetries,
+		},
+		{
+			Path:     httpTimeout,
+			Env:      "RHOSE_HTTP_TIMEOUT",
+			Argument: httpTimeout,
+			Default:  defaultHTTPTimeoutSeconds,
+			Usage:    "The timeout, in seconds, for a single attempt to deliver an event to RHOSE",
+			Value:    &config.httpTimeoutSeconds,
+		},
+		{
+			Path:     secretBackend,
+			Env:      "RHOSE_SECRET_BACKEND",
+			Argument: secretBackend,
+			Default:  defaultSecretBackend,
+			Usage:    "Where to resolve client-secret/client-id and cached tokens from: env, file or keyring",
+			Value:    &config.secretBackend,
+		},
+		{
+			Path:     keyringService,
+			Env:      "RHOSE_KEYRING_SERVICE",
+			Argument: keyringService,
+			Default:  defaultKeyringService,
+			Usage:    "The keyring service name to use when --secret-backend=keyring",
+			Value:    &config.keyringService,
+		},
+		{
+			Path:     ceSource,
+			Env:      "RHOSE_CE_SOURCE",
+			Argument: ceSource,
+			Default:  defaultCESource,
+			Usage:    "The CloudEvent source attribute to send to RHOSE",
+			Value:    &config.ceSource,
+		},
+		{
+			Path:     ceType,
+			Env:      "RHOSE_CE_TYPE",
+			Argument: ceType,
+			Default:  defaultCEType,
+			Usage:    "A Go template, evaluated against the Sensu event, for the CloudEvent type attribute",
+			Value:    &config.ceType,
+		},
+		{
+			Path:     ceSubject,
+			Env:      "RHOSE_CE_SUBJECT",
+			Argument: ceSubject,
+			Default:  defaultCESubject,
+			Usage:    "A Go template, evaluated against the Sensu event, for the CloudEvent subject attribute",
+			Value:    &config.ceSubject,
+		},
+		{
+			Path:     ceExtensions,
+			Env:      "RHOSE_CE_EXTENSIONS",
+			Argument: ceExtensions,
+			Usage:    "A repeatable key=template CloudEvent extension, the template evaluated against the Sensu event",
+			Value:    &config.ceExtensions,
+		},
+		{
+			Path:     dryRun,
+			Env:      "RHOSE_DRY_RUN",
+			Argument: dryRun,
+			Usage:    "Resolve a token and build the CloudEvent, log the request that would be sent, but do not deliver it to RHOSE",
+			Value:    &config.dryRun,
+		},
+		{
+			Path:     logLevel,
+			Env:      "RHOSE_LOG_LEVEL",
+			Argument: logLevel,
+			Default:  defaultLogLevel,
+			Usage:    "The minimum log level to emit: debug, info, warn or error",
+			Value:    &config.logLevel,
+		},
+		{
+			Path:     logFormat,
+			Env:      "RHOSE_LOG_FORMAT",
+			Argument: logFormat,
+			Default:  defaultLogFormat,
+			Usage:    "The log output format: text or json",
+			Value:    &config.logFormat,
+		},
 	}
 )
 
@@ -114,6 +292,20 @@ func main() {
 }
 
 func checkArgs(_ *corev2.Event) error {
+	switch config.secretBackend {
+	case secretBackendEnv:
+	case secretBackendKeyring:
+		if err := loadCredentialsFromKeyring(); err != nil {
+			return err
+		}
+	case secretBackendFile:
+		if err := loadCredentialsFromFile(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid --%s %q: must be one of %s, %s or %s", secretBackend, config.secretBackend, secretBackendEnv, secretBackendFile, secretBackendKeyring)
+	}
+
 	// Support deprecated environment variables
 	if webhook := os.Getenv("RHOSE_WEBHOOK_URL"); webhook != "" {
 		config.rhoseURL = webhook
@@ -122,11 +314,16 @@ func checkArgs(_ *corev2.Event) error {
 	if authenticationEnabled := os.Getenv("AUTHENTICATION_ENABLED"); authenticationEnabled != "" && config.authenticationEnabled == defaultAuthenticationEnabled {
 		config.authenticationEnabled = authenticationEnabled
 
-		if clientID := os.Getenv("RHOSE_CLIENT_ID"); clientID != "" {
-			config.clientID = clientID
-		}
-		if clientSecret := os.Getenv("RHOSE_CLIENT_SECRET"); clientSecret != "" {
-			config.clientSecret = clientSecret
+		// RHOSE_CLIENT_ID/RHOSE_CLIENT_SECRET only apply to the env secret
+		// backend: a keyring- or file-resolved secret must never be clobbered
+		// by a stale plaintext env var left over from an old deployment.
+		if config.secretBackend == secretBackendEnv {
+			if clientID := os.Getenv("RHOSE_CLIENT_ID"); clientID != "" {
+				config.clientID = clientID
+			}
+			if clientSecret := os.Getenv("RHOSE_CLIENT_SECRET"); clientSecret != "" {
+				config.clientSecret = clientSecret
+			}
 		}
 		if ssoURL := os.Getenv("SSO_URL"); ssoURL != "" {
 			config.ssoURL = ssoURL
@@ -147,101 +344,122 @@ func checkArgs(_ *corev2.Event) error {
 		return fmt.Errorf("--%s or RHOSE_WEBHOOK_URL environment variable is required", webHookURL)
 	}
 
-	return nil
-}
-
-func getToken() (string, error) {
-	if config.authenticationEnabled != "yes" {
-		return "", nil
-	}
-
-	if cachedToken != nil { //&& !isJWTTokenExpired(cachedToken.AccessToken) {
-		fmt.Println("CACHED")
-		return cachedToken.AccessToken, nil
+	if len(config.authType) == 0 {
+		if config.authenticationEnabled == "yes" {
+			config.authType = authTypeClientCredentials
+		} else {
+			config.authType = authTypeNone
+		}
 	}
 
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", config.clientID)
-	data.Set("client_secret", config.clientSecret)
-	req, _ := http.NewRequest("POST", config.ssoURL, strings.NewReader(data.Encode()))
-
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	client := &http.Client{}
-	res, err := client.Do(req)
+	provider, err := newTokenProvider(config.authType)
 	if err != nil {
-		return "", fmt.Errorf("Failed to send message to RHOSE: %v", err)
+		return err
 	}
-	defer res.Body.Close()
+	tokenProvider = provider
 
-	var accessTokenResponse AccessTokenResponse
-	err = json.NewDecoder(res.Body).Decode(&accessTokenResponse)
-	if err != nil {
-		return "", fmt.Errorf("Failed to retrieve jwt token: %v", err)
+	return nil
+}
+
+// newTokenProvider builds the TokenProvider selected by --auth-type,
+// validating that the flags it depends on were supplied.
+func newTokenProvider(selectedAuthType string) (TokenProvider, error) {
+	switch selectedAuthType {
+	case authTypeNone:
+		return noopTokenProvider{}, nil
+	case authTypeClientCredentials:
+		if len(config.clientID) == 0 {
+			return nil, fmt.Errorf("--%s or RHOSE_CLIENT_ID environment variable is required", clientID)
+		}
+		if len(config.clientSecret) == 0 {
+			return nil, fmt.Errorf("--%s or RHOSE_CLIENT_SECRET environment variable is required", clientSecret)
+		}
+		if len(config.ssoURL) == 0 {
+			return nil, fmt.Errorf("--%s or SSO_URL environment variable is required", ssoURL)
+		}
+		return NewClientCredentialsProvider(), nil
+	case authTypeRefreshToken:
+		if len(config.refreshTokenFile) == 0 && config.secretBackend != secretBackendKeyring {
+			return nil, fmt.Errorf("--%s is required when --%s=%s", refreshTokenFile, authType, authTypeRefreshToken)
+		}
+		if len(config.clientID) == 0 {
+			return nil, fmt.Errorf("--%s or RHOSE_CLIENT_ID environment variable is required", clientID)
+		}
+		if len(config.ssoURL) == 0 {
+			return nil, fmt.Errorf("--%s or SSO_URL environment variable is required", ssoURL)
+		}
+		return NewRefreshTokenProvider(config.refreshTokenFile), nil
+	case authTypeKeyFile:
+		if len(config.keyFile) == 0 {
+			return nil, fmt.Errorf("--%s is required when --%s=%s", keyFile, authType, authTypeKeyFile)
+		}
+		return NewKeyFileProvider(config.keyFile)
+	case authTypeStatic:
+		if len(config.staticToken) == 0 {
+			return nil, fmt.Errorf("--%s or RHOSE_STATIC_TOKEN environment variable is required when --%s=%s", staticToken, authType, authTypeStatic)
+		}
+		return StaticTokenProvider(config.staticToken), nil
+	default:
+		return nil, fmt.Errorf("invalid --%s %q: must be one of %s, %s, %s, %s or %s", authType, selectedAuthType, authTypeNone, authTypeClientCredentials, authTypeRefreshToken, authTypeKeyFile, authTypeStatic)
 	}
-	cachedToken = &accessTokenResponse
-	return accessTokenResponse.AccessToken, nil
 }
 
 func sendMessage(event *corev2.Event) error {
-	// TODO: retrieve jwt and set it in the request
-	client := &http.Client{}
+	ctx := context.Background()
 
-	token, err := getToken()
+	token, err := tokenProvider.Token(ctx)
 	if err != nil {
 		return fmt.Errorf("Failed to get token from sso %s", err)
 	}
 
-	token, err = getToken()
+	ce, err := buildCloudEvent(event)
 	if err != nil {
-		return fmt.Errorf("Failed to get token from sso %s", err)
+		return fmt.Errorf("Failed to build cloudevent: %v", err)
 	}
 
-	ce := cloudevents.NewEvent()
-	ce.SetSource("sensu/sensu-rhose-handler")
-	ce.SetType("example.type")
-	ce.SetData(cloudevents.ApplicationJSON, event)
-	ce.SetExtension("refreshed", used)
+	a, err := json.Marshal(&ce)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal cloudevent: %v", err)
+	}
 
-	a, _ := json.Marshal(&ce)
+	fields := deliveryFieldsFor(event, ce)
+	getLogger().Debug("cloudevent payload", "entity", fields.Entity, "check", fields.Check, "status", fields.Status, "ce_id", fields.CEID, "payload", string(a))
 
-	fmt.Printf("Event payload %s\n", string(a))
+	if config.dryRun {
+		return logDryRunRequest(ctx, token, a, fields)
+	}
 
-	req, _ := http.NewRequest("POST", config.rhoseURL, bytes.NewBuffer(a))
-	req.Header.Add("Content-Type", "application/cloudevents+json")
-	if config.authenticationEnabled == "yes" {
-		req.Header.Add("Authorization", "Bearer "+token)
+	statusCode, err := postEventWithRetry(ctx, token, a, fields)
+	if err != nil {
+		return err
 	}
-	res, err := client.Do(req)
 
-	fmt.Printf("Event sent to RHOSE ingress with status code %s\n", http.StatusText(res.StatusCode))
+	getLogger().Info("Event sent to RHOSE ingress", "entity", fields.Entity, "check", fields.Check, "status", fields.Status, "ce_id", fields.CEID, "rhose_status_code", statusCode, "rhose_status_text", http.StatusText(statusCode))
+
+	return nil
+}
 
+// logDryRunRequest builds the HTTP request that would have been sent to
+// RHOSE and logs it, with the Authorization header redacted, instead of
+// delivering it. It is used by --dry-run.
+func logDryRunRequest(ctx context.Context, token string, payload []byte, fields deliveryLogFields) error {
+	req, err := buildRHOSERequest(ctx, token, payload)
 	if err != nil {
-		return fmt.Errorf("Failed to send message to RHOSE: %v", err)
+		return err
 	}
 
-	// FUTURE: send to AH
-	fmt.Printf("Event sent to RHOSE ingress %s\n", config.rhoseURL)
+	headers := make(map[string]string, len(req.Header))
+	for name := range req.Header {
+		if name == "Authorization" {
+			headers[name] = "REDACTED"
+			continue
+		}
+		headers[name] = req.Header.Get(name)
+	}
+
+	getLogger().Info("dry-run: would send event to RHOSE",
+		"entity", fields.Entity, "check", fields.Check, "status", fields.Status, "ce_id", fields.CEID,
+		"method", req.Method, "url", req.URL.String(), "headers", headers, "payload", string(payload))
 
 	return nil
 }
-
-// This method returns true if JWT token is expired, otherwise returns false
-// func isJWTTokenExpired(accessToken string) bool {
-// 	var jwksJSON json.RawMessage = []byte(`{"keys":[{"kty":"RSA","e":"AQAB","use":"sig","kid":"MjhhMDk2N2M2NGEwMzgzYjk2OTI3YzdmMGVhOGYxNjI2OTc5Y2Y2MQ","alg":"RS256","n":"zZU9xSgK77PbtkjJgD2Vmmv6_QNe8B54eyOV0k5K2UwuSnhv9RyRA3aL7gDN-qkANemHw3H_4Tc5SKIMltVIYdWlOMW_2m3gDBOODjc1bE-WXEWX6nQkLAOkoFrGW3bgW8TFxfuwgZVTlb6cYkSyiwc5ueFV2xNqo96Qf7nm5E7KZ2QDTkSlNMdW-jIVHMKjuEsy_gtYMaEYrwk5N7VoiYwePaF3I0_g4G2tIrKTLb8DvHApsN1h-s7jMCQFBrY4vCf3RBlYULr4Nz7u8G2NL_L9vURSCU2V2A8rYRkoZoZwk3a3AyJiqeC4T_1rmb8XdrgeFHB5bzXZ7EI0TObhlw"}]}`)
-
-// 	// Create the JWKS from the resource at the given URL.
-// 	jwks, err := keyfunc.NewJSON(jwksJSON)
-// 	if err != nil {
-// 		log.Fatalf("Failed to create JWKS from resource at the given URL.\nError: %s", err.Error())
-// 	}
-
-// 	token, tokenErr := jwt.Parse(accessToken, jwks.Keyfunc)
-// 	if tokenErr != nil {
-// 		fmt.Println(tokenErr)
-// 		return true
-// 	}
-// 	tokenClaims := token.Claims.(jwt.MapClaims)
-// 	exp := tokenClaims["exp"].(float64)
-// 	return exp-float64(time.Now().Unix()) <= 0
-// }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// deliveryLogFields carries the event attributes attached to every
+// structured log line emitted while delivering a CloudEvent to RHOSE.
+type deliveryLogFields struct {
+	Entity string
+	Check  string
+	Status uint32
+	CEID   string
+}
+
+// postEventWithRetry POSTs payload to config.rhoseURL, retrying on network
+// errors and on 5xx/429/408 responses with exponential backoff, up to
+// config.maxRetries additional attempts. A Retry-After header on a 429 or
+// 503 response is honored instead of the computed backoff. Each attempt is
+// logged with its status code and latency. It returns the last seen status
+// code (0 if every attempt failed at the network level) and a non-nil error
+// unless the request ultimately succeeded.
+func postEventWithRetry(ctx context.Context, token string, payload []byte, fields deliveryLogFields) (int, error) {
+	var lastErr error
+	var lastStatusCode int
+	attempts := 0
+
+	for attempt := 0; attempt <= config.maxRetries; attempt++ {
+		attempts = attempt + 1
+		statusCode, retryAfter, latency, err := postEventOnce(ctx, token, payload)
+		if statusCode != 0 {
+			lastStatusCode = statusCode
+		}
+		lastErr = err
+
+		logArgs := []interface{}{
+			"entity", fields.Entity,
+			"check", fields.Check,
+			"status", fields.Status,
+			"ce_id", fields.CEID,
+			"rhose_status_code", statusCode,
+			"attempt", attempt + 1,
+			"latency_ms", latency.Milliseconds(),
+		}
+		if err != nil {
+			getLogger().Warn("RHOSE delivery attempt failed", append(logArgs, "error", err)...)
+		} else {
+			getLogger().Info("RHOSE delivery attempt succeeded", logArgs...)
+			return statusCode, nil
+		}
+
+		if statusCode != 0 && !isRetryableStatus(statusCode) {
+			break
+		}
+		if attempt == config.maxRetries {
+			break
+		}
+		sleepBeforeRetry(attempt, retryAfter)
+	}
+
+	return lastStatusCode, fmt.Errorf("Failed to send message to RHOSE after %d attempt(s): %v", attempts, lastErr)
+}
+
+// buildRHOSERequest builds the POST request used to deliver payload to
+// config.rhoseURL, attaching the bearer token whenever tokenProvider
+// produced one (rather than keying off the legacy --authentication-enabled
+// flag, which every auth-type other than client_credentials bypasses).
+func buildRHOSERequest(ctx context.Context, token string, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", config.rhoseURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build request to RHOSE: %v", err)
+	}
+	req.Header.Add("Content-Type", "application/cloudevents+json")
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// postEventOnce performs a single POST attempt with a per-attempt timeout.
+// It returns the status code (0 if the request never got a response), the
+// Retry-After duration advertised by the response if any, the attempt's
+// latency, and an error when the attempt did not succeed with a 2xx status.
+func postEventOnce(ctx context.Context, token string, payload []byte) (int, time.Duration, time.Duration, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, time.Duration(config.httpTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	req, err := buildRHOSERequest(attemptCtx, token, payload)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	start := time.Now()
+	client := &http.Client{}
+	res, err := client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, 0, latency, fmt.Errorf("Failed to send message to RHOSE: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res.StatusCode, 0, latency, nil
+	}
+
+	return res.StatusCode, retryAfterDuration(res), latency, fmt.Errorf("RHOSE ingress returned status %s", http.StatusText(res.StatusCode))
+}
+
+// isRetryableStatus reports whether statusCode warrants another attempt.
+func isRetryableStatus(statusCode int) bool {
+	if statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return statusCode >= 500
+}
+
+// retryAfterDuration returns the duration advertised by a 429 or 503's
+// Retry-After header (in seconds), or zero if absent, unparsable, or not
+// applicable to this status code.
+func retryAfterDuration(res *http.Response) time.Duration {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	header := res.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+	seconds, err := strconv.Atoi(header)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// sleepBeforeRetry waits for retryAfter if the response advertised one,
+// otherwise for an exponentially increasing, jittered backoff capped at
+// retryMaxDelay.
+func sleepBeforeRetry(attempt int, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		time.Sleep(retryAfter)
+		return
+	}
+
+	delay := retryBaseDelay * time.Duration(1<<uint(attempt))
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	time.Sleep(time.Duration(rand.Int63n(int64(delay))))
+}
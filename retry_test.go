@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableStatus(t *testing.T) {
+	cases := map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusRequestTimeout:      true,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+	}
+	for status, want := range cases {
+		assert.Equal(t, want, isRetryableStatus(status), "status %d", status)
+	}
+}
+
+func TestRetryAfterDuration(t *testing.T) {
+	header := func(statusCode int, retryAfter string) *http.Response {
+		res := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+		if retryAfter != "" {
+			res.Header.Set("Retry-After", retryAfter)
+		}
+		return res
+	}
+
+	assert.Equal(t, 5*time.Second, retryAfterDuration(header(http.StatusTooManyRequests, "5")))
+	assert.Equal(t, 2*time.Second, retryAfterDuration(header(http.StatusServiceUnavailable, "2")))
+	assert.Zero(t, retryAfterDuration(header(http.StatusTooManyRequests, "")), "missing Retry-After should be zero")
+	assert.Zero(t, retryAfterDuration(header(http.StatusTooManyRequests, "not-a-number")), "unparsable Retry-After should be zero")
+	assert.Zero(t, retryAfterDuration(header(http.StatusInternalServerError, "5")), "Retry-After is only honored for 429/503")
+}
+
+// withRetryConfig points config at server and a short timeout/backoff so
+// retry tests run quickly, restoring the previous values afterwards.
+func withRetryConfig(t *testing.T, server *httptest.Server, maxRetries int) {
+	t.Helper()
+	origURL, origMaxRetries, origTimeout := config.rhoseURL, config.maxRetries, config.httpTimeoutSeconds
+	t.Cleanup(func() {
+		config.rhoseURL, config.maxRetries, config.httpTimeoutSeconds = origURL, origMaxRetries, origTimeout
+	})
+	config.rhoseURL = server.URL
+	config.maxRetries = maxRetries
+	config.httpTimeoutSeconds = 5
+}
+
+func TestPostEventWithRetry_SucceedsOnFirstAttempt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	withRetryConfig(t, server, 3)
+
+	statusCode, err := postEventWithRetry(context.Background(), "", []byte("{}"), deliveryLogFields{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestPostEventWithRetry_RetriesOnServerErrorThenSucceeds(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	withRetryConfig(t, server, 5)
+
+	statusCode, err := postEventWithRetry(context.Background(), "", []byte("{}"), deliveryLogFields{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestPostEventWithRetry_DoesNotRetryNonRetryable4xx(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	withRetryConfig(t, server, 5)
+
+	statusCode, err := postEventWithRetry(context.Background(), "", []byte("{}"), deliveryLogFields{})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	assert.Equal(t, 1, attempts, "a non-retryable 4xx should not be retried")
+}
+
+func TestPostEventWithRetry_PropagatesErrorWhenRetriesExhausted(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	withRetryConfig(t, server, 2)
+
+	statusCode, err := postEventWithRetry(context.Background(), "", []byte("{}"), deliveryLogFields{})
+	require.Error(t, err, "a non-nil error must be returned when retries are exhausted")
+	assert.Contains(t, err.Error(), "Internal Server Error")
+	assert.Equal(t, http.StatusInternalServerError, statusCode)
+	assert.Equal(t, 3, attempts, "max-retries additional attempts plus the initial one")
+}
+
+func TestPostEventWithRetry_HonorsRetryAfterHeader(t *testing.T) {
+	attempts := 0
+	var firstAttemptAt, secondAttemptAt time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			firstAttemptAt = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		secondAttemptAt = time.Now()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	withRetryConfig(t, server, 3)
+
+	statusCode, err := postEventWithRetry(context.Background(), "", []byte("{}"), deliveryLogFields{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.GreaterOrEqual(t, secondAttemptAt.Sub(firstAttemptAt), time.Second, "should have waited at least the advertised Retry-After")
+}
+
+func TestBuildRHOSERequest_AttachesAuthorizationOnlyWhenTokenPresent(t *testing.T) {
+	origURL := config.rhoseURL
+	t.Cleanup(func() { config.rhoseURL = origURL })
+	config.rhoseURL = "http://example.invalid"
+
+	withToken, err := buildRHOSERequest(context.Background(), "a-token", []byte("{}"))
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer a-token", withToken.Header.Get("Authorization"))
+
+	withoutToken, err := buildRHOSERequest(context.Background(), "", []byte("{}"))
+	require.NoError(t, err)
+	assert.Empty(t, withoutToken.Header.Get("Authorization"))
+}
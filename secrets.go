@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/99designs/keyring"
+)
+
+var (
+	secretKeyringMutex sync.Mutex
+	secretKeyring      keyring.Keyring
+)
+
+// getKeyring opens the OS keyring selected by --keyring-service the first
+// time a secret is read or written, and hands back that same handle on every
+// later call. Opening is deferred this way because the file backend's
+// passphrase prompt would otherwise run during flag parsing, before
+// --keyring-service is known; the passphrase itself comes from
+// RHOSE_KEYRING_PASSPHRASE so the handler can run non-interactively, as Sensu
+// handlers are never attached to a terminal.
+func getKeyring() (keyring.Keyring, error) {
+	secretKeyringMutex.Lock()
+	defer secretKeyringMutex.Unlock()
+
+	if secretKeyring != nil {
+		return secretKeyring, nil
+	}
+
+	passphrase := os.Getenv("RHOSE_KEYRING_PASSPHRASE")
+	opened, err := keyring.Open(keyring.Config{
+		ServiceName:      config.keyringService,
+		FilePasswordFunc: keyring.FixedStringPrompt(passphrase),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open keyring %s: %v", config.keyringService, err)
+	}
+	secretKeyring = opened
+	return secretKeyring, nil
+}
+
+// getKeyringSecret reads the item stored under key from the configured
+// keyring.
+func getKeyringSecret(key string) (string, error) {
+	kr, err := getKeyring()
+	if err != nil {
+		return "", err
+	}
+	item, err := kr.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %q from keyring: %v", key, err)
+	}
+	return string(item.Data), nil
+}
+
+// setKeyringSecret stores value under key in the configured keyring.
+func setKeyringSecret(key, value string) error {
+	kr, err := getKeyring()
+	if err != nil {
+		return err
+	}
+	return kr.Set(keyring.Item{Key: key, Data: []byte(value)})
+}
+
+// loadCredentialsFromKeyring fills in config.clientID/config.clientSecret
+// from the keyring when they were not supplied on the command line, so
+// operators can avoid putting them in Sensu asset environment variables or
+// check definitions.
+func loadCredentialsFromKeyring() error {
+	if config.clientID == "" {
+		id, err := getKeyringSecret(clientID)
+		if err != nil {
+			return fmt.Errorf("Failed to load --%s from keyring: %v", clientID, err)
+		}
+		config.clientID = id
+	}
+	if config.clientSecret == "" {
+		secret, err := getKeyringSecret(clientSecret)
+		if err != nil {
+			return fmt.Errorf("Failed to load --%s from keyring: %v", clientSecret, err)
+		}
+		config.clientSecret = secret
+	}
+	return nil
+}
+
+// loadCredentialsFromFile fills in config.clientID/config.clientSecret from
+// the files they point at when --secret-backend=file, so the values never
+// need to pass through a plaintext flag or environment variable.
+func loadCredentialsFromFile() error {
+	if config.clientID != "" {
+		id, err := readSecretFile(config.clientID)
+		if err != nil {
+			return fmt.Errorf("Failed to load --%s from file: %v", clientID, err)
+		}
+		config.clientID = id
+	}
+	if config.clientSecret != "" {
+		secret, err := readSecretFile(config.clientSecret)
+		if err != nil {
+			return fmt.Errorf("Failed to load --%s from file: %v", clientSecret, err)
+		}
+		config.clientSecret = secret
+	}
+	return nil
+}
+
+// readSecretFile reads and trims the contents of the file at path.
+func readSecretFile(path string) (string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// refreshTokenStore persists the rotated refresh token used by
+// RefreshTokenProvider, either to a plain file or to the OS keyring
+// depending on --secret-backend.
+type refreshTokenStore interface {
+	Load() (string, error)
+	Save(token string) error
+}
+
+// newRefreshTokenStore returns the refreshTokenStore selected by
+// --secret-backend. path is a filesystem path for the file/env backends, or
+// the keyring item key for the keyring backend (defaulting to
+// defaultRefreshTokenKeyringKey if unset).
+func newRefreshTokenStore(path string) refreshTokenStore {
+	if config.secretBackend == secretBackendKeyring {
+		key := path
+		if key == "" {
+			key = defaultRefreshTokenKeyringKey
+		}
+		return keyringRefreshTokenStore{key: key}
+	}
+	return fileRefreshTokenStore{path: path}
+}
+
+// fileRefreshTokenStore reads and atomically rewrites the refresh token on
+// disk.
+type fileRefreshTokenStore struct {
+	path string
+}
+
+func (s fileRefreshTokenStore) Load() (string, error) {
+	raw, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+func (s fileRefreshTokenStore) Save(token string) error {
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(token), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// keyringRefreshTokenStore stores the refresh token as an item in the OS
+// keyring.
+type keyringRefreshTokenStore struct {
+	key string
+}
+
+func (s keyringRefreshTokenStore) Load() (string, error) {
+	return getKeyringSecret(s.key)
+}
+
+func (s keyringRefreshTokenStore) Save(token string) error {
+	return setKeyringSecret(s.key, token)
+}
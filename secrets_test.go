@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withFileKeyring points getKeyring at a keyring file backend rooted at a
+// temp dir with a fixed passphrase, the same mechanism getKeyring itself uses
+// for RHOSE_KEYRING_PASSPHRASE, so keyring-backed tests are hermetic.
+func withFileKeyring(t *testing.T) {
+	t.Helper()
+	origKeyring, origService := secretKeyring, config.keyringService
+	t.Cleanup(func() {
+		secretKeyring, config.keyringService = origKeyring, origService
+	})
+
+	dir := t.TempDir()
+	opened, err := keyring.Open(keyring.Config{
+		ServiceName:      "sensu-rhose-handler-test",
+		AllowedBackends:  []keyring.BackendType{keyring.FileBackend},
+		FileDir:          dir,
+		FilePasswordFunc: keyring.FixedStringPrompt("test-passphrase"),
+	})
+	require.NoError(t, err)
+	secretKeyring = opened
+}
+
+func TestLoadCredentialsFromFile_ReadsAndTrimsTheConfiguredPaths(t *testing.T) {
+	origClientID, origClientSecret := config.clientID, config.clientSecret
+	t.Cleanup(func() { config.clientID, config.clientSecret = origClientID, origClientSecret })
+
+	dir := t.TempDir()
+	idPath := filepath.Join(dir, "client-id")
+	secretPath := filepath.Join(dir, "client-secret")
+	require.NoError(t, os.WriteFile(idPath, []byte("  my-client-id\n"), 0600))
+	require.NoError(t, os.WriteFile(secretPath, []byte("my-client-secret\n\n"), 0600))
+
+	config.clientID = idPath
+	config.clientSecret = secretPath
+
+	require.NoError(t, loadCredentialsFromFile())
+	assert.Equal(t, "my-client-id", config.clientID)
+	assert.Equal(t, "my-client-secret", config.clientSecret)
+}
+
+func TestLoadCredentialsFromFile_MissingFileIsAnError(t *testing.T) {
+	origClientID, origClientSecret := config.clientID, config.clientSecret
+	t.Cleanup(func() { config.clientID, config.clientSecret = origClientID, origClientSecret })
+
+	config.clientID = filepath.Join(t.TempDir(), "does-not-exist")
+	config.clientSecret = ""
+
+	require.Error(t, loadCredentialsFromFile())
+}
+
+func TestCheckArgs_RejectsUnknownSecretBackend(t *testing.T) {
+	origBackend := config.secretBackend
+	t.Cleanup(func() { config.secretBackend = origBackend })
+	config.secretBackend = "vault"
+
+	err := checkArgs(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --secret-backend")
+}
+
+func TestFileRefreshTokenStore_RoundTrips(t *testing.T) {
+	store := fileRefreshTokenStore{path: filepath.Join(t.TempDir(), "refresh-token")}
+
+	require.NoError(t, store.Save("a-refresh-token"))
+
+	got, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "a-refresh-token", got)
+}
+
+func TestKeyringRefreshTokenStore_RoundTrips(t *testing.T) {
+	withFileKeyring(t)
+
+	store := keyringRefreshTokenStore{key: defaultRefreshTokenKeyringKey}
+	require.NoError(t, store.Save("a-rotated-refresh-token"))
+
+	got, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "a-rotated-refresh-token", got)
+}
+
+func TestCheckArgs_LegacyAuthEnvVarsDoNotClobberResolvedSecretBackend(t *testing.T) {
+	origBackend, origAuthEnabled, origURL, origSSOURL := config.secretBackend, config.authenticationEnabled, config.rhoseURL, config.ssoURL
+	origClientID, origClientSecret := config.clientID, config.clientSecret
+	t.Cleanup(func() {
+		config.secretBackend, config.authenticationEnabled, config.rhoseURL, config.ssoURL = origBackend, origAuthEnabled, origURL, origSSOURL
+		config.clientID, config.clientSecret = origClientID, origClientSecret
+		os.Unsetenv("AUTHENTICATION_ENABLED")
+		os.Unsetenv("RHOSE_CLIENT_ID")
+		os.Unsetenv("RHOSE_CLIENT_SECRET")
+	})
+
+	config.secretBackend = secretBackendKeyring
+	config.authenticationEnabled = defaultAuthenticationEnabled
+	config.rhoseURL = "http://example.invalid"
+	config.ssoURL = "http://sso.example.invalid"
+	config.clientID = "keyring-resolved-id"
+	config.clientSecret = "keyring-resolved-secret"
+	os.Setenv("AUTHENTICATION_ENABLED", "yes")
+	os.Setenv("RHOSE_CLIENT_ID", "stale-env-id")
+	os.Setenv("RHOSE_CLIENT_SECRET", "stale-env-secret")
+
+	require.NoError(t, checkArgs(nil))
+	assert.Equal(t, "keyring-resolved-id", config.clientID, "a keyring-resolved client ID must not be clobbered by a stale env var")
+	assert.Equal(t, "keyring-resolved-secret", config.clientSecret, "a keyring-resolved client secret must not be clobbered by a stale env var")
+}
+
+func TestNewRefreshTokenStore_SelectsBackendBySecretBackend(t *testing.T) {
+	origBackend := config.secretBackend
+	t.Cleanup(func() { config.secretBackend = origBackend })
+
+	config.secretBackend = secretBackendKeyring
+	_, ok := newRefreshTokenStore("some-key").(keyringRefreshTokenStore)
+	assert.True(t, ok, "--secret-backend=keyring should select keyringRefreshTokenStore")
+
+	config.secretBackend = secretBackendFile
+	_, ok = newRefreshTokenStore("/some/path").(fileRefreshTokenStore)
+	assert.True(t, ok, "--secret-backend=file should select fileRefreshTokenStore")
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TokenProvider resolves the bearer token to send with each outgoing RHOSE
+// request. Implementations are responsible for their own caching; sendMessage
+// simply calls Token on every invocation.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// AccessTokenResponse contains the Authorization response object from keycloak
+type AccessTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresAt        int    `json:"expires_at"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	TokenType        string `json:"token_type"`
+	Scope            string `json:"scope"`
+	NotBeforePolicy  int    `json:"not-before-policy"`
+}
+
+// openIDConfiguration is the subset of the OpenID Connect discovery document
+// (RFC: .well-known/openid-configuration) that is needed to locate the JWKS.
+type openIDConfiguration struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// noopTokenProvider is used when authentication is disabled: it never
+// attaches an Authorization header.
+type noopTokenProvider struct{}
+
+func (noopTokenProvider) Token(_ context.Context) (string, error) {
+	return "", nil
+}
+
+// StaticTokenProvider returns a pre-issued bearer token as-is, useful for
+// tests and for users who mint their own service-account JWTs.
+type StaticTokenProvider string
+
+func (p StaticTokenProvider) Token(_ context.Context) (string, error) {
+	return string(p), nil
+}
+
+// ClientCredentialsProvider implements the OAuth2 client_credentials flow
+// against --sso-url, caching the access token until it is close to expiry.
+type ClientCredentialsProvider struct {
+	mu     sync.Mutex
+	cached *AccessTokenResponse
+}
+
+// NewClientCredentialsProvider returns a ClientCredentialsProvider that
+// authenticates with config.clientID/config.clientSecret against config.ssoURL.
+func NewClientCredentialsProvider() *ClientCredentialsProvider {
+	return &ClientCredentialsProvider{}
+}
+
+func (p *ClientCredentialsProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && !isJWTTokenExpired(p.cached.AccessToken) {
+		return p.cached.AccessToken, nil
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+	data.Set("client_id", config.clientID)
+	data.Set("client_secret", config.clientSecret)
+
+	accessTokenResponse, err := requestToken(ctx, config.ssoURL, data)
+	if err != nil {
+		return "", err
+	}
+	p.cached = accessTokenResponse
+	return accessTokenResponse.AccessToken, nil
+}
+
+// RefreshTokenProvider exchanges a long-lived refresh token, read from its
+// refreshTokenStore, for an access token at --sso-url, storing the rotated
+// refresh token back to that store (a plain file by default, or the OS keyring when
+// --secret-backend=keyring).
+type RefreshTokenProvider struct {
+	mu     sync.Mutex
+	store  refreshTokenStore
+	cached *AccessTokenResponse
+}
+
+// NewRefreshTokenProvider returns a RefreshTokenProvider backed by the
+// refreshTokenStore selected by --secret-backend for path.
+func NewRefreshTokenProvider(path string) *RefreshTokenProvider {
+	return &RefreshTokenProvider{store: newRefreshTokenStore(path)}
+}
+
+func (p *RefreshTokenProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && !isJWTTokenExpired(p.cached.AccessToken) {
+		return p.cached.AccessToken, nil
+	}
+
+	refreshToken, err := p.store.Load()
+	if err != nil {
+		return "", fmt.Errorf("Failed to load refresh token: %v", err)
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("client_id", config.clientID)
+	data.Set("client_secret", config.clientSecret)
+	data.Set("refresh_token", refreshToken)
+
+	accessTokenResponse, err := requestToken(ctx, config.ssoURL, data)
+	if err != nil {
+		return "", err
+	}
+
+	if accessTokenResponse.RefreshToken != "" {
+		if err := p.store.Save(accessTokenResponse.RefreshToken); err != nil {
+			return "", fmt.Errorf("Failed to store rotated refresh token: %v", err)
+		}
+	}
+
+	p.cached = accessTokenResponse
+	return accessTokenResponse.AccessToken, nil
+}
+
+// keyFileCredentials is the JSON credentials file format accepted by
+// KeyFileProvider, modeled after the pulsar-client-go oauth2 keyfile scheme.
+type keyFileCredentials struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	IssuerURL    string `json:"issuer_url"`
+	Type         string `json:"type"`
+}
+
+// KeyFileProvider implements the client_credentials flow using credentials
+// read from a JSON keyfile instead of command-line flags.
+type KeyFileProvider struct {
+	mu     sync.Mutex
+	creds  keyFileCredentials
+	cached *AccessTokenResponse
+}
+
+// NewKeyFileProvider reads and parses the JSON credentials file at path.
+func NewKeyFileProvider(path string) (*KeyFileProvider, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key file %s: %v", path, err)
+	}
+	var creds keyFileCredentials
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return nil, fmt.Errorf("Failed to parse key file %s: %v", path, err)
+	}
+	if creds.ClientID == "" || creds.ClientSecret == "" || creds.IssuerURL == "" {
+		return nil, fmt.Errorf("key file %s must set client_id, client_secret and issuer_url", path)
+	}
+	return &KeyFileProvider{creds: creds}, nil
+}
+
+func (p *KeyFileProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && !isJWTTokenExpired(p.cached.AccessToken) {
+		return p.cached.AccessToken, nil
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+	data.Set("client_id", p.creds.ClientID)
+	data.Set("client_secret", p.creds.ClientSecret)
+
+	accessTokenResponse, err := requestToken(ctx, p.creds.IssuerURL, data)
+	if err != nil {
+		return "", err
+	}
+	p.cached = accessTokenResponse
+	return accessTokenResponse.AccessToken, nil
+}
+
+// requestToken POSTs an OAuth2 token request to tokenURL and decodes the
+// resulting access token.
+func requestToken(ctx context.Context, tokenURL string, data url.Values) (*AccessTokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build token request: %v", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to send token request to %s: %v", tokenURL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("token endpoint %s returned status %s: %s", tokenURL, http.StatusText(res.StatusCode), string(body))
+	}
+
+	var accessTokenResponse AccessTokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&accessTokenResponse); err != nil {
+		return nil, fmt.Errorf("Failed to retrieve jwt token: %v", err)
+	}
+	return &accessTokenResponse, nil
+}
+
+var (
+	jwksMutex sync.Mutex
+	jwks      *keyfunc.JWKS
+)
+
+// isJWTTokenExpired returns true if accessToken's "exp" claim is closer than
+// config.tokenLeewaySeconds to the current time, or has already passed. When
+// the JWKS used to verify the token's signature cannot be retrieved, or the
+// token fails verification against it, the token is decoded without
+// verification just to read "exp", so a broken JWKS endpoint does not block
+// alert delivery.
+func isJWTTokenExpired(accessToken string) bool {
+	claims := jwt.MapClaims{}
+
+	jwksInstance, err := getJWKS()
+	if err == nil {
+		_, err = jwt.ParseWithClaims(accessToken, claims, jwksInstance.Keyfunc)
+	}
+	if err != nil {
+		getLogger().Warn("Falling back to unverified JWT parsing; the access token's signature is not being checked", "error", err)
+		if _, _, parseErr := new(jwt.Parser).ParseUnverified(accessToken, claims); parseErr != nil {
+			getLogger().Warn("Failed to parse jwt token, treating it as expired", "error", parseErr)
+			return true
+		}
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return true
+	}
+	return exp-float64(time.Now().Unix()) <= float64(config.tokenLeewaySeconds)
+}
+
+// getJWKS returns the cached JWKS used to verify SSO access token
+// signatures, fetching it on first use (via --jwks-url, or discovered from
+// --sso-url) since it depends on flags that aren't available until after
+// checkArgs has run. Once fetched, keyfunc refreshes it in the background, so
+// the same *keyfunc.JWKS is reused for the lifetime of the process.
+func getJWKS() (*keyfunc.JWKS, error) {
+	jwksMutex.Lock()
+	defer jwksMutex.Unlock()
+
+	if jwks != nil {
+		return jwks, nil
+	}
+
+	jwksEndpoint := config.jwksURL
+	if jwksEndpoint == "" {
+		discovered, err := discoverJWKSURL(config.ssoURL)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to discover jwks url: %v", err)
+		}
+		jwksEndpoint = discovered
+	}
+
+	discovered, err := keyfunc.Get(jwksEndpoint, keyfunc.Options{
+		RefreshInterval:   time.Hour,
+		RefreshUnknownKID: true,
+		RefreshErrorHandler: func(err error) {
+			getLogger().Error("Failed to refresh jwks", "jwks_url", jwksEndpoint, "error", err)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch jwks from %s: %v", jwksEndpoint, err)
+	}
+	jwks = discovered
+	return jwks, nil
+}
+
+// keycloakTokenEndpointSuffix is the path Keycloak/Red Hat SSO append to a
+// realm's issuer URL to form its token endpoint, e.g.
+// https://sso.example.com/auth/realms/foo/protocol/openid-connect/token.
+// --sso-url is expected to be that token endpoint, so it is stripped off to
+// recover the issuer root the discovery document lives under.
+const keycloakTokenEndpointSuffix = "/protocol/openid-connect/token"
+
+// discoverJWKSURL resolves the jwks_uri advertised by the SSO issuer's
+// .well-known/openid-configuration document. The issuer is ssoURL with its
+// token-endpoint suffix removed, keeping the realm path intact, e.g.
+// https://sso.example.com/auth/realms/foo rather than just the scheme and
+// host (which 404s for any realm-scoped issuer).
+func discoverJWKSURL(ssoURL string) (string, error) {
+	if _, err := url.Parse(ssoURL); err != nil {
+		return "", err
+	}
+	issuer := strings.TrimSuffix(strings.TrimRight(ssoURL, "/"), keycloakTokenEndpointSuffix)
+
+	res, err := http.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var discovery openIDConfiguration
+	if err := json.NewDecoder(res.Body).Decode(&discovery); err != nil {
+		return "", err
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("openid-configuration from %s did not advertise a jwks_uri", issuer)
+	}
+	return discovery.JWKSURI, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTestJWT returns an HS256 JWT with the given exp claim. Its signature
+// is never verified by these tests, only its unverified claims are read.
+func buildTestJWT(t *testing.T, exp int64) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": float64(exp)})
+	signed, err := token.SignedString([]byte("test-signing-key"))
+	require.NoError(t, err)
+	return signed
+}
+
+// withUnreachableJWKS points config at a JWKS endpoint nothing listens on, so
+// getJWKS fails and isJWTTokenExpired must fall back to an unverified parse.
+func withUnreachableJWKS(t *testing.T, leewaySeconds int) {
+	t.Helper()
+	origJWKSURL, origSSOURL, origLeeway := config.jwksURL, config.ssoURL, config.tokenLeewaySeconds
+	t.Cleanup(func() {
+		config.jwksURL, config.ssoURL, config.tokenLeewaySeconds = origJWKSURL, origSSOURL, origLeeway
+		jwks = nil
+	})
+	config.jwksURL = "http://127.0.0.1:0/jwks.json"
+	config.ssoURL = "http://127.0.0.1:0"
+	config.tokenLeewaySeconds = leewaySeconds
+	jwks = nil
+}
+
+func TestIsJWTTokenExpired_FallsBackToUnverifiedParseWhenJWKSUnreachable(t *testing.T) {
+	withUnreachableJWKS(t, 30)
+
+	valid := buildTestJWT(t, time.Now().Add(time.Hour).Unix())
+	assert.False(t, isJWTTokenExpired(valid), "a token that expires in an hour should not be treated as expired")
+
+	expired := buildTestJWT(t, time.Now().Add(-time.Hour).Unix())
+	assert.True(t, isJWTTokenExpired(expired), "a token that expired an hour ago should be treated as expired")
+}
+
+func TestIsJWTTokenExpired_HonorsLeeway(t *testing.T) {
+	withUnreachableJWKS(t, 30)
+
+	// Expires in 10 seconds, inside the 30 second leeway: should be treated as expired.
+	withinLeeway := buildTestJWT(t, time.Now().Add(10*time.Second).Unix())
+	assert.True(t, isJWTTokenExpired(withinLeeway), "a token expiring within the leeway window should be treated as expired")
+
+	// Expires in 5 minutes, outside the 30 second leeway: should not be treated as expired.
+	outsideLeeway := buildTestJWT(t, time.Now().Add(5*time.Minute).Unix())
+	assert.False(t, isJWTTokenExpired(outsideLeeway), "a token expiring well after the leeway window should not be treated as expired")
+}
+
+func TestIsJWTTokenExpired_UnparseableTokenIsTreatedAsExpired(t *testing.T) {
+	withUnreachableJWKS(t, 30)
+
+	assert.True(t, isJWTTokenExpired("not-a-jwt"), "a token that cannot be parsed at all should be treated as expired")
+}
+
+func TestDiscoverJWKSURL_StripsTokenEndpointSuffixKeepingRealmPath(t *testing.T) {
+	const jwksURI = "https://sso.example.com/auth/realms/foo/protocol/openid-connect/certs"
+	var requestedPath string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jwks_uri": %q}`, jwksURI)
+	}))
+	defer server.Close()
+
+	ssoURL := server.URL + "/auth/realms/foo/protocol/openid-connect/token"
+
+	discovered, err := discoverJWKSURL(ssoURL)
+	require.NoError(t, err)
+	assert.Equal(t, jwksURI, discovered)
+	assert.Equal(t, "/auth/realms/foo/.well-known/openid-configuration", requestedPath,
+		"discovery must be requested under the realm path, not just the issuer's scheme and host")
+}
+
+func TestDiscoverJWKSURL_MissingJWKSURIIsAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	_, err := discoverJWKSURL(server.URL + "/protocol/openid-connect/token")
+	require.Error(t, err)
+}